@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/glamour"
+)
+
+// markdownRenderer renders msgPacket.Text as Markdown via Glamour, using the
+// dark built-in style since it reads closest to the existing cyan/magenta
+// retro palette. Glamour wraps to a fixed width, so the renderer is rebuilt
+// whenever the viewport width changes.
+type markdownRenderer struct {
+	term  *glamour.TermRenderer
+	width int
+}
+
+func newMarkdownRenderer(width int) (*markdownRenderer, error) {
+	term, err := glamour.NewTermRenderer(
+		glamour.WithStylePath("dark"),
+		glamour.WithWordWrap(width),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &markdownRenderer{term: term, width: width}, nil
+}
+
+func (m *markdownRenderer) render(text string) string {
+	out, err := m.term.Render(text)
+	if err != nil {
+		return messageStyle.Render(text)
+	}
+	return strings.TrimRight(out, "\n")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+const historyDBFileName = "neonwire.db"
+
+// history persists every sent and received chatLine to a local SQLite
+// database (CGO-free via modernc.org/sqlite) so scrollback survives
+// restarts instead of starting empty every time.
+type history struct {
+	db *sql.DB
+}
+
+func historyDBPath() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(exe), historyDBFileName), nil
+}
+
+func openHistory() (*history, error) {
+	path, err := historyDBPath()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	const schema = `
+CREATE TABLE IF NOT EXISTS messages (
+	id        INTEGER PRIMARY KEY AUTOINCREMENT,
+	peer      TEXT NOT NULL,
+	room      TEXT NOT NULL,
+	sender    TEXT NOT NULL,
+	text      TEXT NOT NULL,
+	own       INTEGER NOT NULL,
+	timestamp DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_messages_peer_room ON messages(peer, room, id);
+`
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initializing history db %s: %w", path, err)
+	}
+	return &history{db: db}, nil
+}
+
+// append records one chat line against peer/room. System notices are not
+// persisted; they're derived from slash commands, not conversation content.
+func (h *history) append(peer, room string, l chatLine) error {
+	own := 0
+	if l.own {
+		own = 1
+	}
+	_, err := h.db.Exec(
+		`INSERT INTO messages (peer, room, sender, text, own, timestamp) VALUES (?, ?, ?, ?, ?, ?)`,
+		peer, room, l.sender, l.text, own, l.ts,
+	)
+	return err
+}
+
+// recent returns the last n lines for peer/room in chronological order, for
+// loading into m.rooms[...].messages on startup.
+func (h *history) recent(peer, room string, n int) ([]chatLine, error) {
+	rows, err := h.db.Query(
+		`SELECT sender, text, own, timestamp FROM messages
+		 WHERE peer = ? AND room = ? ORDER BY id DESC LIMIT ?`,
+		peer, room, n,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []chatLine
+	for rows.Next() {
+		var l chatLine
+		var own int
+		if err := rows.Scan(&l.sender, &l.text, &own, &l.ts); err != nil {
+			return nil, err
+		}
+		l.own = own == 1
+		if l.own {
+			l.status = deliveryDelivered
+		}
+		lines = append(lines, l)
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines, rows.Err()
+}
+
+// searchResult is one match from history, paired with the room it came from
+// since /search spans every room for the peer, not just the active one.
+type searchResult struct {
+	room string
+	line chatLine
+}
+
+func (h *history) search(peer, term string) ([]searchResult, error) {
+	rows, err := h.db.Query(
+		`SELECT room, sender, text, own, timestamp FROM messages
+		 WHERE peer = ? AND text LIKE ? ORDER BY id DESC LIMIT 200`,
+		peer, "%"+term+"%",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []searchResult
+	for rows.Next() {
+		var res searchResult
+		var own int
+		if err := rows.Scan(&res.room, &res.line.sender, &res.line.text, &own, &res.line.ts); err != nil {
+			return nil, err
+		}
+		res.line.own = own == 1
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+func (h *history) clear(peer, room string) error {
+	_, err := h.db.Exec(`DELETE FROM messages WHERE peer = ? AND room = ?`, peer, room)
+	return err
+}
+
+func (h *history) Close() error { return h.db.Close() }
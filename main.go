@@ -2,43 +2,153 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
-	"net"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/reflow/wordwrap"
 )
 
 const (
+	// maxWidth and maxHeight are only the assumed terminal size for the
+	// very first render, before bubbletea delivers the initial
+	// tea.WindowSizeMsg; every dimension is recomputed from there on.
 	maxWidth  = 80
 	maxHeight = 24
 	port      = 9999
+
+	inputRows       = 3
+	minSidebarWidth = 16
+	maxSidebarWidth = 28
+	minBodyWidth    = 20
+	minBodyHeight   = 3
 )
 
 type msgPacket struct {
 	Sender    string    `json:"sender"`
+	Room      string    `json:"room"`
 	Text      string    `json:"text"`
 	Timestamp time.Time `json:"timestamp"`
 }
 
-type udpMsg struct {
+// netMsg is the transport-agnostic envelope returned by listenForMessages
+// regardless of whether the active Transport is UDP, TCP, or WebSocket.
+type netMsg struct {
 	data []byte
-	addr *net.UDPAddr
+	addr PeerAddr
 }
 
 type model struct {
-	viewport    viewport.Model
-	textarea    textarea.Model
-	messages    []string
-	username    string
-	remoteAddr  string
-	conn        *net.UDPConn
-	err         error
+	viewport   viewport.Model
+	textarea   textarea.Model
+	roomList   list.Model
+	rooms      []*room
+	activeRoom int
+	username   string
+	remoteAddr string
+	transport  Transport
+	err        error
+
+	keystore        *keystore
+	noise           *noiseSession
+	peerFingerprint string
+	reliability     *reliability
+
+	markdown *markdownRenderer
+	rawMode  bool
+
+	history       *history
+	historyLimit  int
+	searchMode    bool
+	searchResults []searchResult
+	searchView    viewport.Model
+
+	width, height int
+}
+
+func (m *model) current() *room { return m.rooms[m.activeRoom] }
+
+// findRoom returns the room with the given name, creating an implicit group
+// room for it if none exists yet (e.g. the first message routed to a room we
+// were never explicitly /invited into).
+func (m *model) findRoom(name string) *room {
+	for _, r := range m.rooms {
+		if r.name == name {
+			return r
+		}
+	}
+	r := &room{name: name, kind: roomGroup}
+	m.rooms = append(m.rooms, r)
+	m.roomList.SetItems(roomListItems(m.rooms))
+	return r
+}
+
+func (m *model) renderActiveRoom() {
+	lines := m.current().messages
+	rendered := make([]string, len(lines))
+	for i := range lines {
+		rendered[i] = m.renderLine(&lines[i])
+	}
+	m.viewport.SetContent(strings.Join(rendered, "\n"))
+	m.viewport.GotoBottom()
+}
+
+// renderLine renders one chat line: a plain statusStyle line for system
+// notices, or a timestamp/glyph/sender prefix plus the message body in
+// either raw or Glamour-rendered Markdown form depending on m.rawMode. Both
+// the Markdown render and the raw word-wrap are cached on the line and only
+// redone when the viewport width they were wrapped to has since changed.
+func (m *model) renderLine(l *chatLine) string {
+	if l.system {
+		return statusStyle.Render(wordwrap.String(l.text, m.viewport.Width))
+	}
+
+	ts := timestampStyle.Render(l.ts.Format("15:04:05"))
+	userStyle := remoteUsernameStyle
+	if l.own {
+		userStyle = usernameStyle
+	}
+	user := userStyle.Render(l.sender)
+
+	var body string
+	if m.rawMode || m.markdown == nil {
+		if l.wrappedCache == "" || l.wrappedAtWidth != m.viewport.Width {
+			l.wrappedCache = wordwrap.String(l.text, m.viewport.Width)
+			l.wrappedAtWidth = m.viewport.Width
+		}
+		body = messageStyle.Render(l.wrappedCache)
+	} else {
+		if l.renderedCache == "" || l.renderedAtWidth != m.markdown.width {
+			l.renderedCache = m.markdown.render(l.text)
+			l.renderedAtWidth = m.markdown.width
+		}
+		body = l.renderedCache
+	}
+
+	if l.own {
+		glyph := timestampStyle.Render(l.status.glyph())
+		return fmt.Sprintf("%s %s %s: %s", ts, glyph, user, body)
+	}
+	return fmt.Sprintf("%s %s: %s", ts, user, body)
+}
+
+// applyDeliveryUpdate updates a sent message's delivery status and
+// refreshes the viewport if that room is active.
+func (m *model) applyDeliveryUpdate(ps *pendingSend) {
+	if ps == nil || ps.room == nil || ps.msgIndex >= len(ps.room.messages) {
+		return
+	}
+	ps.room.messages[ps.msgIndex].status = ps.status
+	if ps.room == m.current() {
+		m.renderActiveRoom()
+	}
 }
 
 var (
@@ -82,58 +192,226 @@ var (
 			BorderForeground(cyan)
 )
 
-func initialModel(username, remoteAddr string) model {
+// handshakeRole selects which side of the Noise handshake a peer plays, via
+// the --role flag. It's only consulted for the WebSocket backend, where
+// both peers address the same relay URL and so can't elect a role by
+// comparing addresses the way UDP and TCP do.
+type handshakeRole string
+
+const (
+	roleAuto      handshakeRole = ""
+	roleInitiator handshakeRole = "initiator"
+	roleResponder handshakeRole = "responder"
+)
+
+func parseHandshakeRole(s string) (handshakeRole, error) {
+	switch handshakeRole(s) {
+	case roleAuto, roleInitiator, roleResponder:
+		return handshakeRole(s), nil
+	default:
+		return "", fmt.Errorf("unknown role %q (want initiator or responder)", s)
+	}
+}
+
+func initialModel(username, remoteAddr string, kind transportKind, r handshakeRole, historyLimit int) model {
 	ta := textarea.New()
 	ta.Placeholder = "Type your message..."
 	ta.Focus()
 	ta.Prompt = "│ "
 	ta.CharLimit = 280
-	ta.SetWidth(76)
-	ta.SetHeight(3)
+	ta.SetHeight(inputRows)
 	ta.FocusedStyle.CursorLine = lipgloss.NewStyle()
 	ta.ShowLineNumbers = false
 	ta.KeyMap.InsertNewline.SetEnabled(false)
 
-	vp := viewport.New(76, 16)
+	vp := viewport.New(0, 0)
 	vp.SetContent("Connected. Waiting for messages...")
 
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", remoteAddr, port))
+	rooms := []*room{newDirectRoom(remoteAddr), newBroadcastRoom()}
+	roomList := list.New(roomListItems(rooms), list.NewDefaultDelegate(), 0, 0)
+	roomList.Title = "Rooms"
+	roomList.SetShowHelp(false)
+
+	ks, err := loadOrCreateKeystore()
 	if err != nil {
 		return model{err: err}
 	}
 
-	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	var initiator bool
+	if resolvedTransportKind(kind, remoteAddr) == transportWS {
+		// Both peers pass the same relay URL as remoteAddr, so there is no
+		// comparable pair of addresses to elect a role from the way UDP and
+		// TCP do below -- the operator must say which peer goes first.
+		switch r {
+		case roleInitiator:
+			initiator = true
+		case roleResponder:
+			initiator = false
+		default:
+			return model{err: fmt.Errorf("--transport ws requires --role initiator or --role responder (relay peers share one address, so it can't be used to elect a role)")}
+		}
+	} else {
+		// Noise_XX has no rendezvous server to assign roles, so the two
+		// peers agree on who initiates by comparing their own
+		// locally-discovered address against the remote address they were
+		// given: the two peers are looking at the same pair of addresses
+		// from opposite ends, so exactly one of them sees its own address
+		// as the greater one. TCP reuses the same comparison to decide who
+		// dials and who listens.
+		localAddr, err := localAddrFor(remoteAddr, port)
+		if err != nil {
+			return model{err: err}
+		}
+		initiator = localAddr > remoteAddr
+	}
+
+	session, err := newNoiseSession(ks, initiator)
+	if err != nil {
+		return model{err: err}
+	}
+
+	transport, err := dialTransport(kind, port, remoteAddr, initiator)
 	if err != nil {
 		return model{err: err}
 	}
 
 	m := model{
-		textarea:   ta,
-		viewport:   vp,
-		messages:   []string{},
-		username:   username,
-		remoteAddr: addr.String(),
-		conn:       conn,
+		textarea:    ta,
+		viewport:    vp,
+		roomList:    roomList,
+		rooms:       rooms,
+		activeRoom:  0,
+		username:    username,
+		remoteAddr:  remoteAddr,
+		transport:   transport,
+		keystore:    ks,
+		noise:       session,
+		reliability: newReliability(),
+		searchView:  viewport.New(0, 0),
+		width:       maxWidth,
+		height:      maxHeight,
+	}
+	m.applyLayout()
+
+	md, err := newMarkdownRenderer(m.viewport.Width)
+	if err != nil {
+		return model{err: err}
+	}
+	m.markdown = md
+
+	h, err := openHistory()
+	if err != nil {
+		return model{err: err}
+	}
+	m.history = h
+	m.historyLimit = historyLimit
+	for _, r := range rooms {
+		lines, err := h.recent(remoteAddr, r.name, historyLimit)
+		if err != nil {
+			return model{err: err}
+		}
+		r.messages = lines
+	}
+
+	if len(m.current().messages) > 0 {
+		m.renderActiveRoom()
 	}
 
 	return m
 }
 
+// layoutMetrics derives the sidebar width and the body (viewport/search
+// list) width and height from the current terminal size and the actual
+// rendered size of the surrounding chrome, rather than fixed constants, so
+// the layout adapts to any tea.WindowSizeMsg instead of assuming 80×24.
+func (m model) layoutMetrics() (sidebarWidth, bodyWidth, bodyHeight int) {
+	sidebarWidth = m.width / 4
+	if sidebarWidth < minSidebarWidth {
+		sidebarWidth = minSidebarWidth
+	} else if sidebarWidth > maxSidebarWidth {
+		sidebarWidth = maxSidebarWidth
+	}
+
+	hFrame := borderStyle.GetHorizontalFrameSize()
+	bodyWidth = m.width - sidebarWidth - hFrame*2
+	if bodyWidth < minBodyWidth {
+		bodyWidth = minBodyWidth
+	}
+
+	chromeHeight := lipgloss.Height(m.renderTitleBar()) +
+		lipgloss.Height(m.renderInputLabel()) +
+		lipgloss.Height(m.renderFooter()) +
+		2 // the two blank separator lines in View's JoinVertical
+	vFrame := borderStyle.GetVerticalFrameSize()
+	bodyHeight = m.height - chromeHeight - vFrame*2 - inputRows
+	if bodyHeight < minBodyHeight {
+		bodyHeight = minBodyHeight
+	}
+	return
+}
+
+// applyLayout resizes the viewport, search view, textarea, and room list to
+// match the current terminal size, invalidating any cached word-wrap so long
+// message bodies re-wrap at the new width instead of overflowing the border.
+func (m *model) applyLayout() {
+	sidebarWidth, bodyWidth, bodyHeight := m.layoutMetrics()
+
+	m.viewport.Width, m.viewport.Height = bodyWidth, bodyHeight
+	m.searchView.Width, m.searchView.Height = bodyWidth, bodyHeight
+	m.textarea.SetWidth(bodyWidth)
+	m.roomList.SetSize(sidebarWidth, bodyHeight)
+
+	for _, r := range m.rooms {
+		for i := range r.messages {
+			r.messages[i].wrappedCache = ""
+		}
+	}
+}
+
 func (m model) Init() tea.Cmd {
-	return tea.Batch(
-		textarea.Blink,
-		listenForMessages(m.conn),
-	)
+	cmds := []tea.Cmd{textarea.Blink, listenForMessages(m.transport), retransmitTick()}
+	if m.noise.initiator {
+		cmds = append(cmds, sendHandshakeStep(m.transport, m.reliability, m.noise))
+	}
+	return tea.Batch(cmds...)
 }
 
-func listenForMessages(conn *net.UDPConn) tea.Cmd {
+// listenForMessages reads one frame off the active Transport. The returned
+// netMsg is the same shape whether that Transport is UDP, TCP, or WebSocket.
+func listenForMessages(t Transport) tea.Cmd {
 	return func() tea.Msg {
-		buf := make([]byte, 1024)
-		n, addr, err := conn.ReadFromUDP(buf)
+		data, addr, err := t.Recv()
 		if err != nil {
 			return errMsg{err}
 		}
-		return udpMsg{data: buf[:n], addr: addr}
+		return netMsg{data: data, addr: addr}
+	}
+}
+
+type retransmitTickMsg struct{}
+
+// retransmitTick drives reliability.retransmitDue on a fixed interval,
+// independent of whatever else the UI is doing.
+func retransmitTick() tea.Cmd {
+	return tea.Tick(200*time.Millisecond, func(time.Time) tea.Msg {
+		return retransmitTickMsg{}
+	})
+}
+
+// sendHandshakeStep advances our side of the Noise_XX handshake by one
+// message and hands it to the reliability layer to send, ack, and
+// retransmit just like a data frame, so a dropped msg1/msg2/msg3 on a lossy
+// link is retried instead of wedging the handshake forever.
+func sendHandshakeStep(t Transport, r *reliability, session *noiseSession) tea.Cmd {
+	return func() tea.Msg {
+		out, _, err := session.step(nil)
+		if err != nil {
+			return errMsg{err}
+		}
+		if _, err := r.sendHandshake(t, out); err != nil {
+			return errMsg{err}
+		}
+		return nil
 	}
 }
 
@@ -143,99 +421,364 @@ func (e errMsg) Error() string { return e.err.Error() }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var (
-		tiCmd tea.Cmd
-		vpCmd tea.Cmd
+		tiCmd   tea.Cmd
+		vpCmd   tea.Cmd
+		roomCmd tea.Cmd
 	)
 
 	m.textarea, tiCmd = m.textarea.Update(msg)
-	m.viewport, vpCmd = m.viewport.Update(msg)
+	if m.searchMode {
+		m.searchView, vpCmd = m.searchView.Update(msg)
+	} else {
+		m.viewport, vpCmd = m.viewport.Update(msg)
+	}
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		switch msg.Type {
-		case tea.KeyCtrlC, tea.KeyEsc:
+		case tea.KeyCtrlC:
 			return m, tea.Quit
+		case tea.KeyEsc:
+			if m.searchMode {
+				m.searchMode = false
+				break
+			}
+			return m, tea.Quit
+		case tea.KeyCtrlR:
+			m.rawMode = !m.rawMode
+			m.renderActiveRoom()
+		case tea.KeyTab:
+			m.activeRoom = (m.activeRoom + 1) % len(m.rooms)
+			m.current().unread = 0
+			m.roomList.Select(m.activeRoom)
+			m.renderActiveRoom()
 		case tea.KeyEnter:
 			text := strings.TrimSpace(m.textarea.Value())
-			if text != "" {
-				// Send message
-				packet := msgPacket{
-					Sender:    m.username,
-					Text:      text,
-					Timestamp: time.Now(),
-				}
-				data, _ := json.Marshal(packet)
-				addr, _ := net.ResolveUDPAddr("udp", m.remoteAddr)
-				m.conn.WriteToUDP(data, addr)
-
-				// Add to local display
-				ts := timestampStyle.Render(packet.Timestamp.Format("15:04:05"))
-				user := usernameStyle.Render(m.username)
-				msgText := messageStyle.Render(text)
-				m.messages = append(m.messages, fmt.Sprintf("%s %s: %s", ts, user, msgText))
-				m.viewport.SetContent(strings.Join(m.messages, "\n"))
-				m.viewport.GotoBottom()
+			if text == "" {
+				break
+			}
+
+			if cmd, isCmd := parseSlashCommand(text); isCmd {
+				m.runSlashCommand(cmd)
 				m.textarea.Reset()
+				break
+			}
+
+			if !m.noise.established {
+				break
+			}
+
+			room := m.current()
+			packet := msgPacket{
+				Sender:    m.username,
+				Room:      room.name,
+				Text:      text,
+				Timestamp: time.Now(),
+			}
+			data, _ := json.Marshal(packet)
+			sealed, err := m.noise.seal(data)
+			if err != nil {
+				m.err = err
+				return m, nil
+			}
+
+			line := chatLine{
+				ts:     packet.Timestamp,
+				sender: m.username,
+				text:   text,
+				own:    true,
+				status: deliverySending,
+			}
+			msgIndex := len(room.messages)
+			room.messages = append(room.messages, line)
+			if err := m.history.append(m.remoteAddr, room.name, line); err != nil {
+				m.err = err
+				return m, nil
+			}
+			if _, err := m.reliability.send(m.transport, sealed, room, msgIndex); err != nil {
+				m.err = err
+				return m, nil
 			}
+			m.renderActiveRoom()
+			m.textarea.Reset()
 		}
 
-	case udpMsg:
-		var packet msgPacket
-		if err := json.Unmarshal(msg.data, &packet); err == nil {
-			ts := timestampStyle.Render(packet.Timestamp.Format("15:04:05"))
-			user := remoteUsernameStyle.Render(packet.Sender)
-			msgText := messageStyle.Render(packet.Text)
-			m.messages = append(m.messages, fmt.Sprintf("%s %s: %s", ts, user, msgText))
-			m.viewport.SetContent(strings.Join(m.messages, "\n"))
-			m.viewport.GotoBottom()
+	case netMsg:
+		if len(msg.data) == 0 {
+			return m, listenForMessages(m.transport)
+		}
+
+		if msg.data[0] != frameReliable {
+			return m, listenForMessages(m.transport)
+		}
+
+		ready, acked, err := m.reliability.receive(m.transport, msg.data[1:])
+		if err != nil {
+			return m, listenForMessages(m.transport)
+		}
+		if acked != nil {
+			m.applyDeliveryUpdate(acked)
+			return m, listenForMessages(m.transport)
+		}
+
+		// ready holds every seq that's now deliverable in order, which may
+		// be more than one if the seq that just arrived was the last one
+		// needed to unblock a run of already-reassembled later seqs.
+		var cmds []tea.Cmd
+		for _, rm := range ready {
+			if rm.kind == kindHandshake {
+				if m.noise.established {
+					// A duplicate or delayed handshake frame arriving after
+					// the session is already established -- possible if our
+					// own ack for it was lost and the peer retransmitted --
+					// must not be fed back into step, which only accepts
+					// messages for a handshake still in progress.
+					continue
+				}
+				_, done, err := m.noise.step(rm.payload)
+				if err != nil {
+					m.err = err
+					continue
+				}
+				m.peerFingerprint = fingerprint(m.noise.peerPublicKey)
+				if !done {
+					cmds = append(cmds, sendHandshakeStep(m.transport, m.reliability, m.noise))
+				}
+				continue
+			}
+
+			plaintext, err := m.noise.open(rm.payload)
+			if err != nil {
+				// Drop silently: a failed open means a tampered frame, and
+				// we must not surface it as a message. Since ready is
+				// strictly in seq order, this can't desync the frames that
+				// follow the way an out-of-order open would.
+				continue
+			}
+			var packet msgPacket
+			if err := json.Unmarshal(plaintext, &packet); err != nil {
+				continue
+			}
+			room := m.findRoom(packet.Room)
+			line := chatLine{
+				ts:     packet.Timestamp,
+				sender: packet.Sender,
+				text:   packet.Text,
+			}
+			room.messages = append(room.messages, line)
+			if err := m.history.append(m.remoteAddr, room.name, line); err != nil {
+				m.err = err
+				continue
+			}
+			if room == m.current() {
+				m.renderActiveRoom()
+			} else {
+				room.unread++
+				m.roomList.SetItems(roomListItems(m.rooms))
+			}
+		}
+		cmds = append(cmds, listenForMessages(m.transport))
+		return m, tea.Batch(cmds...)
+
+	case retransmitTickMsg:
+		for _, ps := range m.reliability.retransmitDue(m.transport) {
+			m.applyDeliveryUpdate(ps)
+		}
+		return m, retransmitTick()
+
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.applyLayout()
+		if md, err := newMarkdownRenderer(m.viewport.Width); err == nil {
+			m.markdown = md
+		}
+		m.renderActiveRoom()
+		if m.searchMode {
+			m.renderSearchResults()
 		}
-		return m, listenForMessages(m.conn)
 
 	case errMsg:
 		m.err = msg
 		return m, nil
 	}
 
-	return m, tea.Batch(tiCmd, vpCmd)
+	return m, tea.Batch(tiCmd, vpCmd, roomCmd)
 }
 
-func (m model) View() string {
-	if m.err != nil {
-		return fmt.Sprintf("Error: %v\n", m.err)
+// runSlashCommand dispatches one parsed /join, /invite, /leave, /who, or
+// /msg command. Unknown commands print a system line into the active room
+// rather than being sent as chat text.
+func (m *model) runSlashCommand(cmd slashCommand) {
+	cur := m.current()
+	system := func(text string) {
+		cur.messages = append(cur.messages, chatLine{text: "* " + text, system: true})
+		m.renderActiveRoom()
 	}
 
-	// Title bar
-	title := titleStyle.Render("═══ VT100 CHAT ═══")
-	status := statusStyle.Render(fmt.Sprintf("Connected to: %s", m.remoteAddr))
-	titleBar := lipgloss.JoinHorizontal(
-		lipgloss.Left,
-		title,
-		" ",
-		status,
-	)
+	switch cmd.kind {
+	case "join":
+		if cmd.arg == "" {
+			system("usage: /join <name>")
+			return
+		}
+		for idx, r := range m.rooms {
+			if r.name == cmd.arg {
+				m.activeRoom = idx
+				m.current().unread = 0
+				m.roomList.Select(idx)
+				m.renderActiveRoom()
+				return
+			}
+		}
+		m.rooms = append(m.rooms, &room{name: cmd.arg, kind: roomGroup})
+		m.activeRoom = len(m.rooms) - 1
+		m.roomList.SetItems(roomListItems(m.rooms))
+		m.roomList.Select(m.activeRoom)
+		m.renderActiveRoom()
+
+	case "invite":
+		if cmd.arg == "" {
+			system("usage: /invite <addr>")
+			return
+		}
+		cur.peers = append(cur.peers, cmd.arg)
+		cur.kind = roomGroup
+		system(fmt.Sprintf("invited %s to %s", cmd.arg, cur.name))
+
+	case "leave":
+		if len(m.rooms) == 1 {
+			system("cannot leave your last room")
+			return
+		}
+		m.rooms = append(m.rooms[:m.activeRoom], m.rooms[m.activeRoom+1:]...)
+		m.activeRoom = 0
+		m.roomList.SetItems(roomListItems(m.rooms))
+		m.roomList.Select(0)
+		m.renderActiveRoom()
+
+	case "who":
+		if len(cur.peers) == 0 {
+			system("no named peers in this room (direct link to " + m.remoteAddr + ")")
+			return
+		}
+		system("peers: " + strings.Join(cur.peers, ", "))
 
-	// Message viewport with border
-	viewportContent := borderStyle.Width(76).Height(16).Render(m.viewport.View())
+	case "msg":
+		if cmd.arg == "" {
+			system("usage: /msg <user>")
+			return
+		}
+		target := m.findRoom(cmd.arg)
+		target.kind = roomDirect
+		for idx, r := range m.rooms {
+			if r == target {
+				m.activeRoom = idx
+				break
+			}
+		}
+		m.roomList.Select(m.activeRoom)
+		m.renderActiveRoom()
+
+	case "search":
+		if cmd.arg == "" {
+			system("usage: /search <term>")
+			return
+		}
+		results, err := m.history.search(m.remoteAddr, cmd.arg)
+		if err != nil {
+			system("search failed: " + err.Error())
+			return
+		}
+		m.searchResults = results
+		m.renderSearchResults()
+		m.searchMode = true
+
+	case "clear":
+		if err := m.history.clear(m.remoteAddr, cur.name); err != nil {
+			system("clear failed: " + err.Error())
+			return
+		}
+		cur.messages = nil
+		m.renderActiveRoom()
+		system("cleared history for " + cur.name)
+
+	default:
+		system("unknown command: /" + cmd.kind)
+	}
+}
+
+// renderSearchResults fills the search viewport with every match from the
+// last /search, newest first, prefixed with the room each one came from
+// since results span the whole peer rather than just the active room.
+func (m *model) renderSearchResults() {
+	if len(m.searchResults) == 0 {
+		m.searchView.SetContent(statusStyle.Render("no matches"))
+		return
+	}
+	lines := make([]string, len(m.searchResults))
+	for i, res := range m.searchResults {
+		ts := timestampStyle.Render(res.line.ts.Format("15:04:05"))
+		room := timestampStyle.Render("[" + res.room + "]")
+		prefix := fmt.Sprintf("%s %s %s: ", ts, room, res.line.sender)
+		lines[i] = prefix + wordwrap.String(res.line.text, m.searchView.Width)
+	}
+	m.searchView.SetContent(strings.Join(lines, "\n"))
+	m.searchView.GotoTop()
+}
+
+// renderTitleBar, renderInputLabel, and renderFooter each render one line of
+// static chrome. They're their own methods, rather than inlined in View, so
+// layoutMetrics can measure their actual rendered size with lipgloss.Height
+// instead of assuming they're always exactly one row.
+func (m model) renderTitleBar() string {
+	title := titleStyle.Render("═══ VT100 CHAT ═══")
+	statusText := fmt.Sprintf("Room: %s", m.current().name)
+	if m.peerFingerprint != "" {
+		statusText += fmt.Sprintf(" • peer key: %s", m.peerFingerprint)
+	} else {
+		statusText += " • handshaking..."
+	}
+	status := statusStyle.Render(statusText)
+	return lipgloss.JoinHorizontal(lipgloss.Left, title, " ", status)
+}
 
-	// Input area with border
-	inputLabel := lipgloss.NewStyle().
-		Foreground(cyan).
-		Bold(true).
-		Render("┌─ INPUT ─")
-	
-	inputArea := borderStyle.Width(76).Height(3).Render(m.textarea.View())
+func (m model) renderInputLabel() string {
+	return lipgloss.NewStyle().Foreground(cyan).Bold(true).Render("┌─ INPUT ─")
+}
 
-	// Footer
-	footer := lipgloss.NewStyle().
+func (m model) renderFooter() string {
+	return lipgloss.NewStyle().
 		Foreground(gray).
-		Render("ESC/Ctrl+C: quit • ENTER: send")
+		Render("ESC/Ctrl+C: quit • ENTER: send • TAB: next room • Ctrl+R: raw/rendered • /join /invite /leave /who /msg /search /clear")
+}
+
+func (m model) View() string {
+	if m.err != nil {
+		return fmt.Sprintf("Error: %v\n", m.err)
+	}
+
+	titleBar := m.renderTitleBar()
+	inputLabel := m.renderInputLabel()
+	footer := m.renderFooter()
+	sidebarWidth, bodyWidth, bodyHeight := m.layoutMetrics()
+
+	// Sidebar listing every room, and either the active room's viewport or,
+	// while /search results are open, the search results viewport instead.
+	sidebar := borderStyle.Width(sidebarWidth).Height(bodyHeight).Render(m.roomList.View())
+	active := m.viewport
+	if m.searchMode {
+		active = m.searchView
+	}
+	viewportContent := borderStyle.Width(bodyWidth).Height(bodyHeight).Render(active.View())
+	body := lipgloss.JoinHorizontal(lipgloss.Top, sidebar, viewportContent)
+
+	inputArea := borderStyle.Width(bodyWidth).Height(inputRows).Render(m.textarea.View())
 
-	// Combine all elements
 	ui := lipgloss.JoinVertical(
 		lipgloss.Left,
 		titleBar,
 		"",
-		viewportContent,
+		body,
 		"",
 		inputLabel,
 		inputArea,
@@ -246,17 +789,38 @@ func (m model) View() string {
 }
 
 func main() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: chat <username> <remote-address>")
+	transportFlag := flag.String("transport", "udp", "transport backend: udp, tcp, or ws")
+	roleFlag := flag.String("role", "", "Noise handshake role for --transport ws (\"initiator\" or \"responder\"); ignored for udp/tcp, which elect a role automatically")
+	historyLimitFlag := flag.Int("history-limit", 200, "number of past messages to load from history per room on startup")
+	flag.Usage = func() {
+		fmt.Println("Usage: chat [--transport udp|tcp|ws] [--role initiator|responder] <username> <remote-address>")
 		fmt.Println("Example: chat alice 100.64.0.2")
+		fmt.Println("Example: chat alice wss://relay.example.com/neonwire --transport ws --role initiator")
+	}
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	kind, err := parseTransportKind(*transportFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	r, err := parseHandshakeRole(*roleFlag)
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	username := os.Args[1]
-	remoteAddr := os.Args[2]
+	username := flag.Arg(0)
+	remoteAddr := flag.Arg(1)
 
 	p := tea.NewProgram(
-		initialModel(username, remoteAddr),
+		initialModel(username, remoteAddr, kind, r, *historyLimitFlag),
 		tea.WithAltScreen(),
 	)
 
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/flynn/noise"
+	"golang.org/x/crypto/curve25519"
+)
+
+const keystoreFileName = "neonwire.keys"
+
+var cipherSuite = noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashSHA256)
+
+// keystore is this peer's long-term Noise static keypair, persisted next to
+// the binary so the identity (and the fingerprint shown to the user) survives
+// restarts instead of being re-generated every run.
+type keystore struct {
+	static noise.DHKey
+}
+
+func keystorePath() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(exe), keystoreFileName), nil
+}
+
+func loadOrCreateKeystore() (*keystore, error) {
+	path, err := keystorePath()
+	if err != nil {
+		return nil, err
+	}
+
+	if raw, err := os.ReadFile(path); err == nil {
+		priv, decodeErr := hex.DecodeString(string(raw))
+		if decodeErr != nil {
+			return nil, fmt.Errorf("corrupt keystore %s: %w", path, decodeErr)
+		}
+		pub, err := curve25519.X25519(priv, curve25519.Basepoint)
+		if err != nil {
+			return nil, fmt.Errorf("rebuilding static key from %s: %w", path, err)
+		}
+		return &keystore{static: noise.DHKey{Private: priv, Public: pub}}, nil
+	}
+
+	static, err := cipherSuite.GenerateKeypair(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(static.Private)), 0600); err != nil {
+		return nil, fmt.Errorf("writing keystore %s: %w", path, err)
+	}
+	return &keystore{static: static}, nil
+}
+
+// fingerprint renders the first 8 bytes of a static public key as colon-hex,
+// the form shown in the status bar for out-of-band verification.
+func fingerprint(pub []byte) string {
+	if len(pub) > 8 {
+		pub = pub[:8]
+	}
+	s := hex.EncodeToString(pub)
+	out := ""
+	for i := 0; i < len(s); i += 2 {
+		if i > 0 {
+			out += ":"
+		}
+		out += s[i : i+2]
+	}
+	return out
+}
+
+// noiseSession wraps one Noise_XX handshake and the resulting transport
+// cipher states. Send/Recv enforce the strictly increasing nonces that
+// noise.CipherState already tracks internally, so replayed or reordered
+// data frames are rejected rather than silently accepted.
+type noiseSession struct {
+	hs            *noise.HandshakeState
+	send, recv    *noise.CipherState
+	established   bool
+	initiator     bool
+	peerPublicKey []byte
+}
+
+func newNoiseSession(ks *keystore, initiator bool) (*noiseSession, error) {
+	hs, err := noise.NewHandshakeState(noise.Config{
+		CipherSuite:   cipherSuite,
+		Pattern:       noise.HandshakeXX,
+		Initiator:     initiator,
+		StaticKeypair: ks.static,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &noiseSession{hs: hs, initiator: initiator}, nil
+}
+
+// step advances the handshake by one message. If payload is nil this peer is
+// writing the next message; otherwise it is consuming one read from the wire.
+// Once both transport cipher states come back from Split, the session is
+// established and step must not be called again.
+func (s *noiseSession) step(payload []byte) (out []byte, done bool, err error) {
+	if payload == nil {
+		out, cs0, cs1, err := s.hs.WriteMessage(nil, nil)
+		if err != nil {
+			return nil, false, err
+		}
+		if peer := s.hs.PeerStatic(); peer != nil {
+			s.peerPublicKey = peer
+		}
+		if cs0 != nil {
+			s.send, s.recv = cs0, cs1
+			s.established = true
+		}
+		return out, s.established, nil
+	}
+
+	_, cs0, cs1, err := s.hs.ReadMessage(nil, payload)
+	if err != nil {
+		return nil, false, err
+	}
+	if peer := s.hs.PeerStatic(); peer != nil {
+		s.peerPublicKey = peer
+	}
+	if cs0 != nil {
+		s.recv, s.send = cs0, cs1
+		s.established = true
+	}
+	return nil, s.established, nil
+}
+
+func (s *noiseSession) seal(plaintext []byte) ([]byte, error) {
+	if !s.established {
+		return nil, errors.New("noise session not established")
+	}
+	return s.send.Encrypt(nil, nil, plaintext)
+}
+
+func (s *noiseSession) open(ciphertext []byte) ([]byte, error) {
+	if !s.established {
+		return nil, errors.New("noise session not established")
+	}
+	plaintext, err := s.recv.Decrypt(nil, nil, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt failed (possible replay or tamper): %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	// frameReliable tags a reliability-layer frame. Every sealed data frame
+	// and every Noise handshake message travels inside one or more of
+	// these, fragmented to stay MTU-safe.
+	frameReliable byte = 0x02
+
+	maxFragmentPayload = 1200
+	maxRetries         = 5
+	ackTimeout         = 800 * time.Millisecond
+)
+
+const (
+	kindData uint8 = iota + 1
+	kindAck
+	kindHandshake
+)
+
+var errShortFrame = errors.New("reliability: frame shorter than its header")
+
+// fragHeader is the per-fragment metadata carried ahead of every chunk: which
+// message (Seq) it belongs to, how many fragments that message was split
+// into (Total), this fragment's place in the sequence (Index), and whether
+// it's a data fragment or a bare ACK (Kind).
+type fragHeader struct {
+	Seq   uint64 `json:"seq"`
+	Total uint16 `json:"total"`
+	Index uint16 `json:"index"`
+	Kind  uint8  `json:"kind"`
+}
+
+// deliveryState is surfaced as a glyph next to a sent message's timestamp.
+type deliveryState int
+
+const (
+	deliverySending deliveryState = iota
+	deliveryDelivered
+	deliveryFailed
+)
+
+func (s deliveryState) glyph() string {
+	switch s {
+	case deliveryDelivered:
+		return "✓"
+	case deliveryFailed:
+		return "✗"
+	default:
+		return "…"
+	}
+}
+
+// pendingSend tracks one outgoing message, already split into fragments,
+// until every fragment has been acked, it's given up after maxRetries.
+// room/msgIndex identify the rendered line in that room's scrollback so its
+// delivery glyph can be rewritten in place as the status changes; a
+// handshake send has no such line, so both are left zero and
+// applyDeliveryUpdate skips it.
+type pendingSend struct {
+	fragments [][]byte
+	attempts  int
+	lastSent  time.Time
+	status    deliveryState
+	kind      uint8
+	room      *room
+	msgIndex  int
+}
+
+// reassembly collects fragments for one inbound seq until all have arrived.
+type reassembly struct {
+	total  uint16
+	chunks map[uint16][]byte
+}
+
+// readyMessage is one fully reassembled inbound frame handed back by
+// receive, in strictly increasing seq order with its ACK already sent. kind
+// tells the caller whether payload is a Noise handshake message or a sealed
+// data frame.
+type readyMessage struct {
+	seq     uint64
+	kind    uint8
+	payload []byte
+}
+
+// reliability is the sequencing/fragmentation/retransmit layer sitting
+// between the Noise session and the UDP socket. It assigns each sealed data
+// frame a monotonically increasing sequence id, splits it into MTU-safe
+// fragments, and retransmits with exponential backoff until the remote ACKs.
+//
+// Inbound frames are handed to Noise strictly in seq order: Noise's
+// CipherState nonces are implicit and sequential, so opening two frames out
+// of order permanently desyncs the session. nextExpected is the next seq
+// Noise is owed, and ready buffers any seq that finished reassembling
+// before the seqs ahead of it arrived (e.g. a fragment of seq N was lost
+// while seq N+1 arrived whole). The same sequencing covers handshake
+// messages, so a dropped msg1/msg2/msg3 is retransmitted by retransmitDue
+// exactly like a dropped data frame instead of wedging the handshake with
+// no recovery.
+type reliability struct {
+	nextSeq      uint64
+	inFlight     map[uint64]*pendingSend
+	inbound      map[uint64]*reassembly
+	nextExpected uint64
+	ready        map[uint64]readyMessage
+}
+
+func newReliability() *reliability {
+	return &reliability{
+		inFlight: make(map[uint64]*pendingSend),
+		inbound:  make(map[uint64]*reassembly),
+		ready:    make(map[uint64]readyMessage),
+	}
+}
+
+// send fragments a sealed frame and writes every fragment to the wire under
+// a fresh sequence id, remembering it for retransmit until acked.
+func (r *reliability) send(t Transport, sealed []byte, rm *room, msgIndex int) (uint64, error) {
+	return r.sendFragments(t, kindData, sealed, rm, msgIndex)
+}
+
+// sendHandshake fragments and writes one Noise handshake message the same
+// way send writes a data frame, so it gets the same ack/retransmit/dedup
+// coverage: a dropped msg1/msg2/msg3 on a lossy link is retried with backoff
+// instead of wedging the handshake forever.
+func (r *reliability) sendHandshake(t Transport, payload []byte) (uint64, error) {
+	return r.sendFragments(t, kindHandshake, payload, nil, 0)
+}
+
+func (r *reliability) sendFragments(t Transport, kind uint8, payload []byte, rm *room, msgIndex int) (uint64, error) {
+	seq := r.nextSeq
+	r.nextSeq++
+
+	var fragments [][]byte
+	for off := 0; off < len(payload); off += maxFragmentPayload {
+		end := off + maxFragmentPayload
+		if end > len(payload) {
+			end = len(payload)
+		}
+		fragments = append(fragments, payload[off:end])
+	}
+	if len(fragments) == 0 {
+		fragments = [][]byte{{}}
+	}
+
+	r.inFlight[seq] = &pendingSend{
+		fragments: fragments,
+		lastSent:  time.Now(),
+		status:    deliverySending,
+		kind:      kind,
+		room:      rm,
+		msgIndex:  msgIndex,
+	}
+
+	for idx, frag := range fragments {
+		if err := r.writeFragment(t, seq, uint16(len(fragments)), uint16(idx), kind, frag); err != nil {
+			return seq, err
+		}
+	}
+	return seq, nil
+}
+
+func (r *reliability) writeFragment(t Transport, seq uint64, total, index uint16, kind uint8, payload []byte) error {
+	header, err := json.Marshal(fragHeader{Seq: seq, Total: total, Index: index, Kind: kind})
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 0, 3+len(header)+len(payload))
+	frame = append(frame, frameReliable)
+	frame = append(frame, byte(len(header)>>8), byte(len(header)))
+	frame = append(frame, header...)
+	frame = append(frame, payload...)
+	return t.Send(frame)
+}
+
+// ack sends a bare acknowledgement for a fully reassembled seq.
+func (r *reliability) ack(t Transport, seq uint64) error {
+	return r.writeFragment(t, seq, 1, 0, kindAck, nil)
+}
+
+// receive parses one reliability frame (body is everything after the
+// frameReliable tag byte). On an ACK it marks the matching send delivered
+// and returns it so the caller can update its glyph.
+//
+// On a data fragment, once every fragment for that seq has arrived, it acks
+// the seq and either buffers the reassembled payload (if seqs before it are
+// still outstanding) or returns it, along with any seqs immediately after it
+// that were already buffered and are now unblocked, in order. If the seq
+// was already delivered by an earlier call (the sender retransmitted
+// because our ACK for it was lost), it re-acks without re-delivering or
+// re-buffering.
+func (r *reliability) receive(t Transport, body []byte) (ready []readyMessage, acked *pendingSend, err error) {
+	if len(body) < 2 {
+		return nil, nil, errShortFrame
+	}
+	headerLen := int(body[0])<<8 | int(body[1])
+	if len(body) < 2+headerLen {
+		return nil, nil, errShortFrame
+	}
+	var h fragHeader
+	if err := json.Unmarshal(body[2:2+headerLen], &h); err != nil {
+		return nil, nil, err
+	}
+	chunk := body[2+headerLen:]
+
+	if h.Kind == kindAck {
+		ps, ok := r.inFlight[h.Seq]
+		if ok {
+			ps.status = deliveryDelivered
+			delete(r.inFlight, h.Seq)
+		}
+		return nil, ps, nil
+	}
+
+	buf, ok := r.inbound[h.Seq]
+	if !ok {
+		buf = &reassembly{total: h.Total, chunks: make(map[uint16][]byte)}
+		r.inbound[h.Seq] = buf
+	}
+	buf.chunks[h.Index] = chunk
+	if uint16(len(buf.chunks)) < buf.total {
+		return nil, nil, nil
+	}
+
+	var assembled []byte
+	for i := uint16(0); i < buf.total; i++ {
+		assembled = append(assembled, buf.chunks[i]...)
+	}
+	delete(r.inbound, h.Seq)
+
+	if h.Seq < r.nextExpected {
+		r.ack(t, h.Seq)
+		return nil, nil, nil
+	}
+
+	r.ready[h.Seq] = readyMessage{seq: h.Seq, kind: h.Kind, payload: assembled}
+	for {
+		rm, ok := r.ready[r.nextExpected]
+		if !ok {
+			break
+		}
+		delete(r.ready, r.nextExpected)
+		r.ack(t, r.nextExpected)
+		ready = append(ready, rm)
+		r.nextExpected++
+	}
+	return ready, nil, nil
+}
+
+// retransmitDue resends any in-flight message that has waited past its
+// backoff window, doubling the window each attempt, and gives up after
+// maxRetries. It returns the sends that just flipped to deliveryFailed so
+// the caller can update their glyphs.
+func (r *reliability) retransmitDue(t Transport) []*pendingSend {
+	var justFailed []*pendingSend
+	now := time.Now()
+	for seq, ps := range r.inFlight {
+		if ps.status != deliverySending {
+			continue
+		}
+		backoff := ackTimeout << uint(ps.attempts)
+		if now.Sub(ps.lastSent) < backoff {
+			continue
+		}
+		if ps.attempts >= maxRetries {
+			ps.status = deliveryFailed
+			justFailed = append(justFailed, ps)
+			delete(r.inFlight, seq)
+			continue
+		}
+		ps.attempts++
+		ps.lastSent = now
+		for idx, frag := range ps.fragments {
+			r.writeFragment(t, seq, uint16(len(ps.fragments)), uint16(idx), ps.kind, frag)
+		}
+	}
+	return justFailed
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+)
+
+// chatLine is one line of scrollback: either a chat message or a system
+// notice from a slash command. Markdown rendering and raw word-wrapping of
+// text are each cached per line (see glamour.go, main.go's renderLine) so
+// redraws don't redo either unless the width they were done at has changed.
+type chatLine struct {
+	ts     time.Time
+	sender string
+	text   string
+	own    bool
+	status deliveryState
+	system bool
+
+	renderedAtWidth int
+	renderedCache   string
+
+	wrappedAtWidth int
+	wrappedCache   string
+}
+
+// roomKind distinguishes how a room's membership is established.
+type roomKind int
+
+const (
+	roomDirect roomKind = iota
+	roomGroup
+	roomBroadcast
+)
+
+// room is one conversation thread: a direct peer, a named group of peers, or
+// a broadcast channel. Each room keeps its own scrollback and unread count so
+// switching rooms doesn't lose context.
+type room struct {
+	name     string
+	kind     roomKind
+	peers    []string
+	messages []chatLine
+	unread   int
+}
+
+func newDirectRoom(peerAddr string) *room {
+	return &room{name: peerAddr, kind: roomDirect, peers: []string{peerAddr}}
+}
+
+func newBroadcastRoom() *room {
+	return &room{name: "broadcast", kind: roomBroadcast}
+}
+
+// roomItem adapts *room to bubbles' list.Item so rooms can be rendered in the
+// sidebar list without the room type itself depending on the list package.
+type roomItem struct{ r *room }
+
+func (i roomItem) Title() string {
+	title := i.r.name
+	if i.r.unread > 0 {
+		title = fmt.Sprintf("%s (%d)", title, i.r.unread)
+	}
+	return title
+}
+
+func (i roomItem) Description() string {
+	switch i.r.kind {
+	case roomBroadcast:
+		return "broadcast"
+	case roomGroup:
+		return fmt.Sprintf("group · %d peers", len(i.r.peers))
+	default:
+		return "direct"
+	}
+}
+
+func (i roomItem) FilterValue() string { return i.r.name }
+
+func roomListItems(rooms []*room) []list.Item {
+	items := make([]list.Item, len(rooms))
+	for idx, r := range rooms {
+		items[idx] = roomItem{r}
+	}
+	return items
+}
+
+// slashCommand is a parsed `/join`, `/invite`, `/leave`, `/who`, or `/msg`
+// entered in the textarea. kind is empty for plain chat text.
+type slashCommand struct {
+	kind string
+	arg  string
+}
+
+func parseSlashCommand(text string) (slashCommand, bool) {
+	if !strings.HasPrefix(text, "/") {
+		return slashCommand{}, false
+	}
+	fields := strings.SplitN(text[1:], " ", 2)
+	cmd := slashCommand{kind: strings.ToLower(fields[0])}
+	if len(fields) > 1 {
+		cmd.arg = strings.TrimSpace(fields[1])
+	}
+	return cmd, true
+}
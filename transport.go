@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+// PeerAddr identifies the remote endpoint a transport is talking to, in
+// whatever form that backend natively addresses peers.
+type PeerAddr string
+
+// Transport abstracts the wire so the rest of neonwire (Noise handshake,
+// reliability layer, room routing) never touches a net.Conn directly and
+// doesn't care whether frames travel over UDP, TCP, or WebSocket.
+type Transport interface {
+	Send([]byte) error
+	Recv() ([]byte, PeerAddr, error)
+	Close() error
+}
+
+// transportKind selects a Transport implementation via the --transport flag.
+type transportKind string
+
+const (
+	transportUDP transportKind = "udp"
+	transportTCP transportKind = "tcp"
+	transportWS  transportKind = "ws"
+)
+
+func parseTransportKind(s string) (transportKind, error) {
+	switch transportKind(s) {
+	case transportUDP, transportTCP, transportWS:
+		return transportKind(s), nil
+	default:
+		return "", fmt.Errorf("unknown transport %q (want udp, tcp, or ws)", s)
+	}
+}
+
+// localAddrFor discovers the local IP this host would use to reach
+// remoteAddr, by opening (but never writing to) a UDP "connection" to it and
+// reading back the kernel's chosen source address. Both peers run this
+// against the address they were each given on the command line, so the two
+// peers arrive at the same {addrA, addrB} pair from opposite ends -- which
+// is what lets the role election in initialModel compare them symmetrically.
+func localAddrFor(remoteAddr string, port int) (string, error) {
+	host := remoteAddr
+	if u, err := url.Parse(remoteAddr); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	conn, err := net.Dial("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// resolvedTransportKind returns the backend dialTransport will actually use:
+// a remoteAddr of the form "wss://..." or "ws://..." forces the WebSocket
+// backend regardless of kind, so a hosted relay URL can simply be passed as
+// the remote address. initialModel calls this too, since whether the
+// WebSocket backend is in play changes how the Noise initiator role is
+// decided (see the comment in initialModel).
+func resolvedTransportKind(kind transportKind, remoteAddr string) transportKind {
+	if strings.HasPrefix(remoteAddr, "ws://") || strings.HasPrefix(remoteAddr, "wss://") {
+		return transportWS
+	}
+	return kind
+}
+
+// dialTransport establishes the chosen backend. initiator decides which side
+// dials and which side listens for TCP and WebSocket, since unlike UDP those
+// backends are connection-oriented.
+func dialTransport(kind transportKind, localPort int, remoteAddr string, initiator bool) (Transport, error) {
+	kind = resolvedTransportKind(kind, remoteAddr)
+
+	switch kind {
+	case transportTCP:
+		if initiator {
+			return dialTCP(remoteAddr, localPort)
+		}
+		return listenTCP(localPort)
+	case transportWS:
+		return dialWS(remoteAddr)
+	default:
+		return newUDPTransport(localPort, remoteAddr)
+	}
+}
+
+// --- UDP --------------------------------------------------------------
+
+type udpTransport struct {
+	conn   *net.UDPConn
+	remote *net.UDPAddr
+}
+
+func newUDPTransport(localPort int, remoteAddr string) (*udpTransport, error) {
+	remote, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", remoteAddr, localPort))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: localPort})
+	if err != nil {
+		return nil, err
+	}
+	return &udpTransport{conn: conn, remote: remote}, nil
+}
+
+func (t *udpTransport) Send(b []byte) error {
+	_, err := t.conn.WriteToUDP(b, t.remote)
+	return err
+}
+
+func (t *udpTransport) Recv() ([]byte, PeerAddr, error) {
+	buf := make([]byte, 2048)
+	n, addr, err := t.conn.ReadFromUDP(buf)
+	if err != nil {
+		return nil, "", err
+	}
+	return buf[:n], PeerAddr(addr.String()), nil
+}
+
+func (t *udpTransport) Close() error { return t.conn.Close() }
+
+// --- TCP ----------------------------------------------------------------
+
+// tcpTransport frames messages with a 4-byte big-endian length prefix so
+// Recv knows exactly how much to read off the stream.
+type tcpTransport struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialTCP(remoteAddr string, port int) (*tcpTransport, error) {
+	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", remoteAddr, port))
+	if err != nil {
+		return nil, err
+	}
+	return &tcpTransport{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func listenTCP(port int) (*tcpTransport, error) {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &tcpTransport{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func (t *tcpTransport) Send(b []byte) error {
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(b)))
+	if _, err := t.conn.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := t.conn.Write(b)
+	return err
+}
+
+func (t *tcpTransport) Recv() ([]byte, PeerAddr, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(t.reader, header[:]); err != nil {
+		return nil, "", err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(t.reader, buf); err != nil {
+		return nil, "", err
+	}
+	return buf, PeerAddr(t.conn.RemoteAddr().String()), nil
+}
+
+func (t *tcpTransport) Close() error { return t.conn.Close() }
+
+// --- WebSocket ------------------------------------------------------------
+
+// wsFrame carries an opaque payload over wsjson; json.Marshal base64-encodes
+// the []byte automatically, so handshake and reliability frames (already
+// binary) pass through untouched.
+type wsFrame struct {
+	Data []byte `json:"data"`
+}
+
+type wsTransport struct {
+	ctx  context.Context
+	conn *websocket.Conn
+	peer PeerAddr
+}
+
+func dialWS(url string) (*wsTransport, error) {
+	ctx := context.Background()
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &wsTransport{ctx: ctx, conn: conn, peer: PeerAddr(url)}, nil
+}
+
+func (t *wsTransport) Send(b []byte) error {
+	ctx, cancel := context.WithTimeout(t.ctx, 10*time.Second)
+	defer cancel()
+	return wsjson.Write(ctx, t.conn, wsFrame{Data: b})
+}
+
+func (t *wsTransport) Recv() ([]byte, PeerAddr, error) {
+	var frame wsFrame
+	if err := wsjson.Read(t.ctx, t.conn, &frame); err != nil {
+		return nil, "", err
+	}
+	return frame.Data, t.peer, nil
+}
+
+func (t *wsTransport) Close() error {
+	return t.conn.Close(websocket.StatusNormalClosure, "neonwire client closing")
+}